@@ -15,10 +15,12 @@ package modules
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 
 	"github.com/spf13/cast"
 
@@ -61,14 +63,19 @@ func CreateProjectModule(cfg config.Provider) (Module, error) {
 }
 
 func (h *Client) Collect() (ModulesConfig, error) {
-	mc, coll := h.collect(true)
+	mc, coll := h.collect(true, false)
 	return mc, coll.err
 
 }
 
-func (h *Client) collect(tidy bool) (ModulesConfig, *collector) {
+// collect builds the module graph. skipHashCheck disables hugo.sum
+// verification for the duration of the pass -- used by Verify, which needs
+// to walk every module and report mismatches itself rather than have the
+// first one abort the collection.
+func (h *Client) collect(tidy, skipHashCheck bool) (ModulesConfig, *collector) {
 	c := &collector{
-		Client: h,
+		Client:        h,
+		skipHashCheck: skipHashCheck,
 	}
 
 	c.collect()
@@ -114,21 +121,52 @@ type ModulesConfig struct {
 }
 
 type collected struct {
-	// Pick the first and prevent circular loops.
-	seen map[string]bool
+	// Tracks which importNode currently owns each (prefix, major version),
+	// so that a module imported by more than one subtree (or a circular
+	// import) is only ever resolved and added once, deterministically --
+	// see claimPath.
+	claims map[pathVersionKey]*claim
 
 	// Maps module path to a _vendor dir. These values are fetched from
 	// _vendor/modules.txt, and the first (top-most) will win.
 	vendored map[string]vendoredModule
 
+	// Records the resolved version per (prefix, major version) so we can
+	// detect and report incompatible versions of the same major pulled in
+	// from different places in the graph.
+	majorVersions map[pathVersionKey]string
+
+	// Set if this collection encountered more than one major version of
+	// the same module.
+	hasMultipleMajorVersions bool
+
 	// Set if a Go modules enabled project.
 	gomods goModules
 
+	// Parsed hugo.sum lockfile, used to verify the integrity of every
+	// resolved module dir. Empty (no entries) if hugo.sum does not exist.
+	lockSum *hugoSum
+
+	// Caches the result of resolveSource per import path, so that once one
+	// goroutine has resolved a path (possibly via a slow clone or tarball
+	// extract), a sibling subtree's diamond import of the same path reuses
+	// the result instead of resolving it again.
+	resolvedSources map[string]*resolvedSource
+
 	// Ordered list of collected modules, including Go Modules and theme
 	// components stored below /themes.
 	modules Modules
 }
 
+// pathVersionKey identifies a module by its unversioned import path prefix
+// and major version suffix (e.g. "" for v0/v1, "/v2" for v2 etc.), so that
+// github.com/foo/bar and github.com/foo/bar/v2 are treated as distinct
+// modules rather than collapsed into one.
+type pathVersionKey struct {
+	prefix string
+	major  string
+}
+
 // Collects and creates a module tree.
 type collector struct {
 	*Client
@@ -139,34 +177,140 @@ type collector struct {
 	// Set to disable any Tidy operation in the end.
 	skipTidy bool
 
+	// Set to disable hugo.sum verification for this collection pass. Used
+	// by Client.Verify, which needs to walk and hash every module itself
+	// rather than have collector.add abort on the first mismatch.
+	skipHashCheck bool
+
+	// Number of resolution tasks addAndRecurse runs concurrently. Zero means
+	// use workerCount's default (GOMAXPROCS).
+	workers int
+
+	// Coalesces concurrent resolutions of the same module path so e.g. a
+	// "go get" is never shelled out for the same path twice in parallel.
+	fetchGroup singleflightKeys
+
+	// Coalesces concurrent ModuleSource.Resolve calls for the same import
+	// path, so a diamond import using e.g. source = "git" or "oci" never
+	// runs two concurrent clones/extracts into the same sourceCacheDir.
+	// Deliberately a separate group from fetchGroup: resolveSource's own
+	// singleflight call can itself call into resolveGoModule, which
+	// singleflights on fetchGroup using the same path key, and a group
+	// can't singleflight into itself on the same key without deadlocking.
+	sourceGroup singleflightKeys
+
+	// Guards every field of *collected below, all of which are written from
+	// the worker pool in addAndRecurse.
+	mu sync.Mutex
+
 	*collected
 }
 
 func (c *collector) initModules() error {
 	c.collected = &collected{
-		seen:     make(map[string]bool),
-		vendored: make(map[string]vendoredModule),
+		claims:          make(map[pathVersionKey]*claim),
+		vendored:        make(map[string]vendoredModule),
+		majorVersions:   make(map[pathVersionKey]string),
+		resolvedSources: make(map[string]*resolvedSource),
+	}
+
+	lockSum, err := readHugoSum(c.fs, c.lockFilePath())
+	if err != nil {
+		return err
 	}
+	c.lockSum = lockSum
 
 	// We may fail later if we don't find the mods.
 	return c.loadModules()
 }
 
-func (c *collector) isSeen(path string) bool {
+// claim records node's attempt to own path and reports whether node is the
+// rightful owner. Ownership is decided by node.path, the node's position in
+// the import tree (root's 1st import, that import's 3rd import, and so
+// on) -- the same position that would have determined precedence in the
+// old, strictly serial depth-first walk -- rather than by which goroutine
+// happens to resolve the module first.
+//
+// If node's position is earlier than whatever currently owns path,
+// including a node that raced ahead and "won" already, claim takes the
+// claim over and prunes the previous owner (and, transitively, its already
+// scheduled subtree) from the result. This also doubles as the graph's
+// cycle guard: a circular import always produces a deeper (and so never
+// earlier) path than the one that first pulled it in, so it can never win
+// a claim back.
+func (c *collector) claim(path string, node *importNode) bool {
 	key := pathKey(path)
-	if c.seen[key] {
-		return true
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if existing, found := c.claims[key]; found {
+		if !lessImportPath(node.path, existing.node.path) {
+			return false
+		}
+		existing.node.prune()
+	}
+
+	c.claims[key] = &claim{node: node}
+	return true
+}
+
+// claim is the current owner of a module path.
+type claim struct {
+	node *importNode
+}
+
+// lessImportPath reports whether a is earlier, in import-tree preorder
+// terms, than b: the two are compared element by element, and a path that
+// is a strict prefix of the other -- i.e. an ancestor of it -- is the
+// earlier one.
+func lessImportPath(a, b []int) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
 	}
-	c.seen[key] = true
-	return false
+	return len(a) < len(b)
+}
+
+// checkMajorVersionConflict records the resolved version for path's
+// (prefix, major) pair and fails with a clear error if an incompatible
+// version of the same major was already pulled in elsewhere in the graph.
+// It also flags c.hasMultipleMajorVersions so the caller can warn once
+// a graph is known to contain more than one major version of a module.
+func (c *collector) checkMajorVersionConflict(path, version string) error {
+	key := pathKey(path)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if prev, found := c.majorVersions[key]; found {
+		if version != "" && prev != "" && version != prev {
+			return errors.Errorf("incompatible versions of module %q: both %q and %q are required", key.prefix, prev, version)
+		}
+		return nil
+	}
+
+	c.majorVersions[key] = version
+
+	for other := range c.majorVersions {
+		if other.prefix == key.prefix && other.major != key.major {
+			c.hasMultipleMajorVersions = true
+			break
+		}
+	}
+
+	return nil
 }
 
 func (c *collector) getVendoredDir(path string) (vendoredModule, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	v, found := c.vendored[path]
 	return v, found
 }
 
-func (c *collector) add(owner *moduleAdapter, moduleImport Import, disabled bool) (*moduleAdapter, error) {
+func (c *collector) add(ctx context.Context, owner *moduleAdapter, moduleImport Import, disabled bool) (*moduleAdapter, error) {
 	var (
 		mod       *goModule
 		moduleDir string
@@ -192,56 +336,43 @@ func (c *collector) add(owner *moduleAdapter, moduleImport Import, disabled bool
 
 			if owner.projectMod {
 				// We want to keep the go.mod intact with the versions and all.
+				c.mu.Lock()
 				c.skipTidy = true
+				c.mu.Unlock()
 			}
 
 		}
 	}
 
 	if moduleDir == "" {
-		mod = c.gomods.GetByPath(modulePath)
-		if mod != nil {
-			moduleDir = mod.Dir
+		rs, err := c.resolveSource(ctx, moduleImport)
+		if err != nil {
+			return nil, err
 		}
 
-		if moduleDir == "" {
+		moduleDir, version, mod = rs.dir, rs.version, rs.mod
 
-			if c.GoModulesFilename != "" && c.isProbablyModule(modulePath) {
-				// Try to "go get" it and reload the module configuration.
-				if err := c.Get(modulePath); err != nil {
-					return nil, err
-				}
-				if err := c.loadModules(); err != nil {
-					return nil, err
-				}
-
-				mod = c.gomods.GetByPath(modulePath)
-				if mod != nil {
-					moduleDir = mod.Dir
-				}
-			}
-
-			// Fall back to /themes/<mymodule>
-			if moduleDir == "" {
-				moduleDir = filepath.Join(c.themesDir, modulePath)
-
-				if found, _ := afero.Exists(c.fs, moduleDir); !found {
-					c.err = c.wrapModuleNotFound(errors.Errorf(`module %q not found; either add it as a Hugo Module or store it in %q.`, modulePath, c.themesDir))
-					return nil, nil
-				}
-			}
+		if moduleDir == "" {
+			return nil, c.wrapModuleNotFound(errors.Errorf(`module %q not found; either add it as a Hugo Module or store it in %q.`, modulePath, c.themesDir))
 		}
 	}
 
 	if found, _ := afero.Exists(c.fs, moduleDir); !found {
-		c.err = c.wrapModuleNotFound(errors.Errorf("%q not found", moduleDir))
-		return nil, nil
+		return nil, c.wrapModuleNotFound(errors.Errorf("%q not found", moduleDir))
 	}
 
 	if !strings.HasSuffix(moduleDir, fileSeparator) {
 		moduleDir += fileSeparator
 	}
 
+	if err := c.checkMajorVersionConflict(modulePath, version); err != nil {
+		return nil, err
+	}
+
+	if err := c.verifyModuleHash(modulePath, moduleDir); err != nil {
+		return nil, err
+	}
+
 	ma := &moduleAdapter{
 		dir:      moduleDir,
 		vendor:   vendored,
@@ -270,11 +401,93 @@ func (c *collector) add(owner *moduleAdapter, moduleImport Import, disabled bool
 		return nil, err
 	}
 
-	c.modules = append(c.modules, ma)
 	return ma, nil
 
 }
 
+// resolvedSource is the cached outcome of resolving a single import path
+// against its configured (or default) ModuleSource chain.
+type resolvedSource struct {
+	dir     string
+	version string
+	mod     *goModule
+	err     error
+}
+
+// resolveSource resolves moduleImport against its configured `source`, or
+// the default Go-modules/themes-dir chain if none is set. Concurrent
+// resolutions of the same import path are coalesced via c.sourceGroup, so a
+// diamond import (two subtrees importing the same path) never runs two
+// clones/downloads into the same sourceCacheDir at once; the loser simply
+// waits for and reuses the first caller's result.
+func (c *collector) resolveSource(ctx context.Context, moduleImport Import) (*resolvedSource, error) {
+	modulePath := moduleImport.Path
+
+	err := c.sourceGroup.do(modulePath, func() error {
+		c.mu.Lock()
+		_, found := c.resolvedSources[modulePath]
+		c.mu.Unlock()
+		if found {
+			return nil
+		}
+
+		rs := &resolvedSource{}
+
+		var sources []ModuleSource
+		if moduleImport.Source != "" {
+			source, err := moduleSourceByName(moduleImport.Source)
+			if err != nil {
+				rs.err = err
+				c.setResolvedSource(modulePath, rs)
+				return nil
+			}
+			sources = []ModuleSource{source}
+		} else {
+			sources = defaultModuleSources(c)
+		}
+
+		for _, source := range sources {
+			dir, resolvedVersion, err := source.Resolve(ctx, c, moduleImport)
+			if err != nil && !IsNotExist(err) {
+				rs.err = err
+				break
+			}
+			if dir != "" {
+				rs.dir = dir
+				rs.version = resolvedVersion
+				if _, ok := source.(*goModuleSource); ok {
+					rs.mod, _ = c.resolveGoModule(modulePath)
+				}
+				break
+			}
+		}
+
+		c.setResolvedSource(modulePath, rs)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	rs := c.resolvedSources[modulePath]
+	c.mu.Unlock()
+
+	return rs, rs.err
+}
+
+func (c *collector) setResolvedSource(path string, rs *resolvedSource) {
+	c.mu.Lock()
+	c.resolvedSources[path] = rs
+	c.mu.Unlock()
+}
+
+// addAndRecurse resolves owner's full import graph. Resolution of each
+// import (which may involve network I/O: go get, a git clone, a tarball
+// download) runs on a bounded worker pool, so independent subtrees resolve
+// concurrently; the result is then walked back into c.modules in the exact
+// order the old, strictly serial depth-first walk would have produced, so
+// ModulesConfig.AllModules and mount precedence are unchanged.
 func (c *collector) addAndRecurse(owner *moduleAdapter, disabled bool) error {
 	moduleConfig := owner.Config()
 	if owner.projectMod {
@@ -283,23 +496,76 @@ func (c *collector) addAndRecurse(owner *moduleAdapter, disabled bool) error {
 		}
 	}
 
-	for _, moduleImport := range moduleConfig.Imports {
+	g := newResolveGroup(c.workerCount())
+
+	root := &importNode{mod: owner, path: []int{}}
+	c.scheduleImports(g, root, moduleConfig.Imports, disabled)
+
+	if err := g.wait(); err != nil {
+		return err
+	}
+
+	appendResolved(&c.modules, root)
+
+	return nil
+}
+
+// scheduleImports submits a resolution task per import in imports to g's
+// worker pool, after first winning that import's claim (see
+// collector.claim) -- the only point where a shared or circular import is
+// deduplicated. Each task resolves the module and, on success, recurses
+// into that module's own imports from within the same task, so an entire
+// subtree can resolve concurrently with its siblings'. node.children is
+// pre-sized so tasks can write results back by their original index
+// regardless of completion order.
+func (c *collector) scheduleImports(g *resolveGroup, node *importNode, imports []Import, disabled bool) {
+	node.children = make([]*importNode, len(imports))
+
+	for i, moduleImport := range imports {
+		i, moduleImport := i, moduleImport
 		disabled := disabled || moduleImport.Disable
 
-		if !c.isSeen(moduleImport.Path) {
-			tc, err := c.add(owner, moduleImport, disabled)
+		child := &importNode{path: append(append([]int{}, node.path...), i)}
+
+		if !c.claim(moduleImport.Path, child) {
+			continue
+		}
+
+		node.children[i] = child
+
+		g.run(func(ctx context.Context) error {
+			tc, err := c.add(ctx, node.mod, moduleImport, disabled)
 			if err != nil {
+				if IsNotExist(err) {
+					// Matches the old behaviour: a missing module is a
+					// soft failure that is recorded but does not stop the
+					// rest of the graph from resolving.
+					c.mu.Lock()
+					c.err = err
+					c.mu.Unlock()
+					child.prune()
+					return nil
+				}
 				return err
 			}
-			if tc == nil {
-				continue
+
+			// Only record the result if child still owns the path: a claim
+			// taken over while add was running has already pruned child.
+			c.mu.Lock()
+			owned := c.claims[pathKey(moduleImport.Path)].node == child
+			if owned {
+				child.mod = tc
 			}
-			if err := c.addAndRecurse(tc, disabled); err != nil {
-				return err
+			c.mu.Unlock()
+			if !owned {
+				return nil
 			}
-		}
+
+			c.scheduleImports(g, child, tc.Config().Imports, disabled)
+
+			return nil
+		})
 	}
-	return nil
 }
 
 func (c *collector) applyMounts(moduleImport Import, mod *moduleAdapter) error {
@@ -433,6 +699,10 @@ func (c *collector) collect() {
 	// Append the project module at the tail.
 	c.modules = append(c.modules, projectMod)
 
+	if c.hasMultipleMajorVersions {
+		c.logger.WARN.Println("Module graph contains multiple major versions of the same module; run \"hugo mod graph\" for more information.")
+	}
+
 }
 
 func (c *collector) collectModulesTXT(owner Module) error {
@@ -463,13 +733,23 @@ func (c *collector) collectModulesTXT(owner Module) error {
 			return errors.Errorf("invalid modules list: %q", filename)
 		}
 		path := parts[0]
+		version := parts[1]
+
+		if c.lockSum != nil && !c.skipHashCheck {
+			if entry, found := c.lockSum.get(path); found && entry.Version != version {
+				return errors.Errorf("%s: vendored module %q is at version %q, but hugo.sum records %q; the vendor tree may have been tampered with (run \"hugo mod verify\")", filename, path, version, entry.Version)
+			}
+		}
+
+		c.mu.Lock()
 		if _, found := c.vendored[path]; !found {
 			c.vendored[path] = vendoredModule{
 				Owner:   owner,
 				Dir:     filepath.Join(vendorDir, path),
-				Version: parts[1],
+				Version: version,
 			}
 		}
+		c.mu.Unlock()
 
 	}
 	return nil
@@ -480,10 +760,52 @@ func (c *collector) loadModules() error {
 	if err != nil {
 		return err
 	}
+	c.mu.Lock()
 	c.gomods = modules
+	c.mu.Unlock()
 	return nil
 }
 
+// resolveGoModule returns the already-loaded go.mod entry for path, running
+// (and reloading from) "go get" on a miss. Concurrent callers for the same
+// path are coalesced via c.fetchGroup so it is never shelled out twice at
+// once for the same module.
+func (c *collector) resolveGoModule(path string) (*goModule, error) {
+	c.mu.Lock()
+	mod := c.gomods.GetByPath(path)
+	c.mu.Unlock()
+	if mod != nil {
+		return mod, nil
+	}
+
+	if c.GoModulesFilename == "" || !c.isProbablyModule(path) {
+		return nil, nil
+	}
+
+	err := c.fetchGroup.do(path, func() error {
+		c.mu.Lock()
+		alreadyFetched := c.gomods.GetByPath(path) != nil
+		c.mu.Unlock()
+		if alreadyFetched {
+			return nil
+		}
+
+		if err := c.Get(path); err != nil {
+			return err
+		}
+		return c.loadModules()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	mod = c.gomods.GetByPath(path)
+	c.mu.Unlock()
+
+	return mod, nil
+}
+
 func (c *collector) normalizeMounts(owner Module, mounts []Mount) ([]Mount, error) {
 	var out []Mount
 	dir := owner.Dir()
@@ -563,12 +885,13 @@ func createProjectModule(gomod *goModule, workingDir string, conf Config) *modul
 
 }
 
-// In the first iteration of Hugo Modules, we do not support multiple
-// major versions running at the same time, so we pick the first (upper most).
-// We will investigate namespaces in future versions.
-// TODO(bep) add a warning when the above happens.
-func pathKey(p string) string {
-	prefix, _, _ := module.SplitPathVersion(p)
+// pathKey splits p into its unversioned prefix and major version suffix
+// (e.g. "/v2"), so that distinct major versions of the same module can be
+// resolved, mounted and vendored side by side instead of being collapsed
+// into the first one seen. Note that this is about the module path itself;
+// the resolved semver version is tracked separately in collected.majorVersions.
+func pathKey(p string) pathVersionKey {
+	prefix, major, _ := module.SplitPathVersion(p)
 
-	return strings.ToLower(prefix)
+	return pathVersionKey{prefix: strings.ToLower(prefix), major: major}
 }
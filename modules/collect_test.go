@@ -0,0 +1,139 @@
+// Copyright 2019 The Hugo Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package modules
+
+import (
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+// TestClaimIsDeterministicForDiamondImports verifies that a module imported
+// by two sibling subtrees (a diamond dependency) is always claimed by the
+// earlier-declared subtree, regardless of which goroutine's claim call
+// happens to run first -- i.e. ownership is decided by import-tree
+// position, not by completion timing.
+func TestClaimIsDeterministicForDiamondImports(t *testing.T) {
+	// Two subtrees, root's 1st and 2nd imports, both importing the same
+	// shared module as their own 1st import: path {0,0} should always win
+	// over path {1,0}.
+	earlier := &importNode{path: []int{0, 0}}
+	later := &importNode{path: []int{1, 0}}
+
+	const runs = 200
+	for i := 0; i < runs; i++ {
+		c := &collector{collected: &collected{claims: make(map[pathVersionKey]*claim)}}
+
+		var wg sync.WaitGroup
+		wg.Add(2)
+
+		// Randomize which goroutine gets to run first, to simulate the
+		// worker pool's scheduling being a race in practice.
+		first, second := earlier, later
+		if rand.Intn(2) == 0 {
+			first, second = later, earlier
+		}
+
+		go func() {
+			defer wg.Done()
+			c.claim("github.com/foo/bar", first)
+		}()
+		go func() {
+			defer wg.Done()
+			c.claim("github.com/foo/bar", second)
+		}()
+		wg.Wait()
+
+		owner, ok := c.claims[pathKey("github.com/foo/bar")]
+		if !ok {
+			t.Fatalf("run %d: expected a claim to be recorded", i)
+		}
+		if owner.node != earlier {
+			t.Fatalf("run %d: expected the earlier-declared import ({0,0}) to own the shared path, got %v", i, owner.node.path)
+		}
+	}
+}
+
+// TestLessImportPath checks the preorder comparison claim relies on,
+// including the prefix (ancestor) case that also serves as the graph's
+// cycle guard.
+func TestLessImportPath(t *testing.T) {
+	cases := []struct {
+		a, b []int
+		want bool
+	}{
+		{[]int{0}, []int{1}, true},
+		{[]int{1}, []int{0}, false},
+		{[]int{0, 0}, []int{0, 1}, true},
+		{[]int{0}, []int{0, 0}, true},   // a is an ancestor of b: a wins
+		{[]int{0, 0}, []int{0}, false},  // b is an ancestor of a: a does not win
+		{[]int{0, 1}, []int{0, 1}, false},
+	}
+
+	for _, tc := range cases {
+		if got := lessImportPath(tc.a, tc.b); got != tc.want {
+			t.Errorf("lessImportPath(%v, %v) = %v, want %v", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
+
+// TestCheckMajorVersionConflict covers the three outcomes a second import
+// of an already-seen (prefix, major) pair can produce: a hard conflict
+// error for an incompatible version of the same major, a silent
+// hasMultipleMajorVersions flag for a different, compatible major, and a
+// no-op for a plain repeat of the same version.
+func TestCheckMajorVersionConflict(t *testing.T) {
+	newCollector := func() *collector {
+		return &collector{collected: &collected{majorVersions: make(map[pathVersionKey]string)}}
+	}
+
+	t.Run("same major, different version errors", func(t *testing.T) {
+		c := newCollector()
+		if err := c.checkMajorVersionConflict("github.com/foo/bar", "v1.0.0"); err != nil {
+			t.Fatalf("first import: unexpected error: %v", err)
+		}
+		if err := c.checkMajorVersionConflict("github.com/foo/bar", "v1.1.0"); err == nil {
+			t.Fatalf("expected an error for incompatible versions of the same major")
+		}
+		if c.hasMultipleMajorVersions {
+			t.Fatalf("a version conflict within one major is not a multiple-major-versions case")
+		}
+	})
+
+	t.Run("different major, same prefix sets hasMultipleMajorVersions", func(t *testing.T) {
+		c := newCollector()
+		if err := c.checkMajorVersionConflict("github.com/foo/bar", "v1.0.0"); err != nil {
+			t.Fatalf("v1 import: unexpected error: %v", err)
+		}
+		if err := c.checkMajorVersionConflict("github.com/foo/bar/v2", "v2.0.0"); err != nil {
+			t.Fatalf("v2 import: unexpected error: %v", err)
+		}
+		if !c.hasMultipleMajorVersions {
+			t.Fatalf("expected hasMultipleMajorVersions to be set once a second major of the same module is seen")
+		}
+	})
+
+	t.Run("same version repeated is a no-op", func(t *testing.T) {
+		c := newCollector()
+		if err := c.checkMajorVersionConflict("github.com/foo/bar", "v1.0.0"); err != nil {
+			t.Fatalf("first import: unexpected error: %v", err)
+		}
+		if err := c.checkMajorVersionConflict("github.com/foo/bar", "v1.0.0"); err != nil {
+			t.Fatalf("repeat import of the same version: unexpected error: %v", err)
+		}
+		if c.hasMultipleMajorVersions {
+			t.Fatalf("repeating the same (prefix, major, version) is not a multiple-major-versions case")
+		}
+	})
+}
@@ -0,0 +1,255 @@
+// Copyright 2019 The Hugo Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package modules
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+)
+
+// lockFilename is hugo.sum, the modules subsystem's integrity lockfile. It
+// sits next to go.mod for Go modules enabled projects, or standalone in the
+// working dir otherwise, and records a content hash for every resolved
+// module dir -- including ones found under _vendor and themesDir -- so a
+// tampered or drifted module is caught instead of silently used.
+const lockFilename = "hugo.sum"
+
+// lockEntry is one recorded line in hugo.sum.
+type lockEntry struct {
+	Path    string
+	Version string
+	Hash    string
+}
+
+// hugoSum is the parsed, in-memory form of a hugo.sum lockfile, keyed by
+// module path.
+type hugoSum struct {
+	entries map[string]lockEntry
+}
+
+func newHugoSum() *hugoSum {
+	return &hugoSum{entries: make(map[string]lockEntry)}
+}
+
+func (s *hugoSum) get(path string) (lockEntry, bool) {
+	e, found := s.entries[path]
+	return e, found
+}
+
+func (s *hugoSum) set(e lockEntry) {
+	s.entries[e.Path] = e
+}
+
+// lockFilePath returns where hugo.sum lives for this collector's project.
+func (c *collector) lockFilePath() string {
+	if c.GoModulesFilename != "" {
+		return filepath.Join(filepath.Dir(c.GoModulesFilename), lockFilename)
+	}
+	return filepath.Join(c.workingDir, lockFilename)
+}
+
+// verifyModuleHash compares dir's content hash against the hash recorded
+// for path in hugo.sum, if any. A missing entry is not an error -- the
+// module simply isn't locked yet, e.g. until the next "hugo mod verify
+// --update" -- but a present, mismatching one aborts with a clear diff.
+func (c *collector) verifyModuleHash(path, dir string) error {
+	if c.skipHashCheck || c.lockSum == nil {
+		return nil
+	}
+
+	entry, found := c.lockSum.get(path)
+	if !found {
+		return nil
+	}
+
+	hash, err := hashModuleDir(c.fs, dir)
+	if err != nil {
+		return err
+	}
+
+	if hash != entry.Hash {
+		return errors.Errorf("verification failed for module %q: checksum mismatch\n\thave %s\n\twant %s\n\t(run \"hugo mod verify --update\" if this change was intentional)", path, hash, entry.Hash)
+	}
+
+	return nil
+}
+
+// VerifyDiff describes a single module whose on-disk content no longer
+// matches what is recorded in hugo.sum.
+type VerifyDiff struct {
+	Path     string
+	Got      string
+	Expected string
+}
+
+func (d VerifyDiff) String() string {
+	return fmt.Sprintf("%s: checksum mismatch\n\thave %s\n\twant %s", d.Path, d.Got, d.Expected)
+}
+
+// Verify re-collects the module graph and reports every module whose
+// resolved directory no longer matches the hash recorded in hugo.sum,
+// without building the site. With update set, it instead rewrites hugo.sum
+// to match the currently resolved modules.
+func (h *Client) Verify(update bool) ([]VerifyDiff, error) {
+	// skipHashCheck: this pass must walk every module and compare hashes
+	// itself (see the loop below) rather than have collector.add abort the
+	// whole collection on the first mismatch it meets.
+	mc, coll := h.collect(false, true)
+	if coll.err != nil {
+		return nil, coll.err
+	}
+
+	sumFilename := coll.lockFilePath()
+	sum, err := readHugoSum(h.fs, sumFilename)
+	if err != nil {
+		return nil, err
+	}
+
+	var diffs []VerifyDiff
+
+	for _, mod := range mc.AllModules {
+		hash, err := hashModuleDir(h.fs, mod.Dir())
+		if err != nil {
+			return nil, err
+		}
+
+		entry, found := sum.get(mod.Path())
+		if !found || update {
+			sum.set(lockEntry{Path: mod.Path(), Version: mod.Version(), Hash: hash})
+			continue
+		}
+
+		if entry.Hash != hash {
+			diffs = append(diffs, VerifyDiff{Path: mod.Path(), Got: hash, Expected: entry.Hash})
+		}
+	}
+
+	if update {
+		if err := writeHugoSum(h.fs, sumFilename, sum); err != nil {
+			return nil, err
+		}
+		return nil, nil
+	}
+
+	return diffs, nil
+}
+
+func readHugoSum(fs afero.Fs, filename string) (*hugoSum, error) {
+	sum := newHugoSum()
+
+	f, err := fs.Open(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return sum, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.Fields(line)
+		if len(parts) != 3 {
+			return nil, errors.Errorf("invalid %s line: %q", lockFilename, line)
+		}
+		sum.set(lockEntry{Path: parts[0], Version: parts[1], Hash: parts[2]})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return sum, nil
+}
+
+func writeHugoSum(fs afero.Fs, filename string, sum *hugoSum) error {
+	paths := make([]string, 0, len(sum.entries))
+	for path := range sum.entries {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var b strings.Builder
+	for _, path := range paths {
+		e := sum.entries[path]
+		version := e.Version
+		if version == "" {
+			version = "-"
+		}
+		fmt.Fprintf(&b, "%s %s %s\n", e.Path, version, e.Hash)
+	}
+
+	return afero.WriteFile(fs, filename, []byte(b.String()), 0o644)
+}
+
+// hashModuleDir computes a content hash of dir, in the spirit of Go's h1:
+// dirhash: a SHA-256 over one "<sha256(file)>  <relative path>" line per
+// file below dir, in sorted relative-path order, so the result depends only on file
+// contents and relative layout, never on mtimes or absolute paths.
+func hashModuleDir(fs afero.Fs, dir string) (string, error) {
+	var files []string
+
+	err := afero.Walk(fs, dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	sort.Strings(files)
+
+	h := sha256.New()
+	for _, rel := range files {
+		f, err := fs.Open(filepath.Join(dir, rel))
+		if err != nil {
+			return "", err
+		}
+
+		fh := sha256.New()
+		_, copyErr := io.Copy(fh, f)
+		f.Close()
+		if copyErr != nil {
+			return "", copyErr
+		}
+
+		fmt.Fprintf(h, "%x  %s\n", fh.Sum(nil), rel)
+	}
+
+	return "h1:" + base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,111 @@
+// Copyright 2019 The Hugo Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package modules
+
+import (
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestHugoSumRoundTrip(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	const filename = "hugo.sum"
+
+	want := newHugoSum()
+	want.set(lockEntry{Path: "github.com/foo/bar", Version: "v1.2.3", Hash: "h1:abc="})
+	want.set(lockEntry{Path: "github.com/foo/bar/v2", Version: "v2.0.0", Hash: "h1:def="})
+	want.set(lockEntry{Path: "github.com/no-version/theme", Version: "", Hash: "h1:ghi="})
+
+	if err := writeHugoSum(fs, filename, want); err != nil {
+		t.Fatalf("writeHugoSum: %v", err)
+	}
+
+	got, err := readHugoSum(fs, filename)
+	if err != nil {
+		t.Fatalf("readHugoSum: %v", err)
+	}
+
+	for path, wantEntry := range want.entries {
+		gotEntry, found := got.get(path)
+		if !found {
+			t.Fatalf("entry for %q missing after round trip", path)
+		}
+		if gotEntry.Hash != wantEntry.Hash {
+			t.Errorf("%q: got hash %q, want %q", path, gotEntry.Hash, wantEntry.Hash)
+		}
+		// A module with no resolved version (e.g. a theme dir source) round
+		// trips to "-" rather than "", since hugo.sum's format is three
+		// whitespace-separated fields.
+		wantVersion := wantEntry.Version
+		if wantVersion == "" {
+			wantVersion = "-"
+		}
+		if gotEntry.Version != wantVersion {
+			t.Errorf("%q: got version %q, want %q", path, gotEntry.Version, wantVersion)
+		}
+	}
+}
+
+func TestReadHugoSumMissingFileIsNotAnError(t *testing.T) {
+	fs := afero.NewMemMapFs()
+
+	sum, err := readHugoSum(fs, "does-not-exist.sum")
+	if err != nil {
+		t.Fatalf("readHugoSum: %v", err)
+	}
+	if len(sum.entries) != 0 {
+		t.Fatalf("expected no entries for a missing lockfile, got %d", len(sum.entries))
+	}
+}
+
+// TestHashModuleDirDetectsMismatch covers the hashing primitive that both
+// normal-build verification and "hugo mod verify" rely on: the hash must be
+// stable for unchanged content and change as soon as a file's content does,
+// and skipHashCheck's short-circuit in verifyModuleHash (see lockfile.go)
+// depends on that hash actually catching drift in the first place.
+func TestHashModuleDirDetectsMismatch(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	dir := "/cache/modules/github.com/foo/bar"
+
+	if err := afero.WriteFile(fs, dir+"/file.txt", []byte("original content"), 0o644); err != nil {
+		t.Fatalf("write module file: %v", err)
+	}
+
+	hash1, err := hashModuleDir(fs, dir)
+	if err != nil {
+		t.Fatalf("hashModuleDir: %v", err)
+	}
+
+	hash1Again, err := hashModuleDir(fs, dir)
+	if err != nil {
+		t.Fatalf("hashModuleDir: %v", err)
+	}
+	if hash1 != hash1Again {
+		t.Fatalf("hashModuleDir should be stable for unchanged content: got %q then %q", hash1, hash1Again)
+	}
+
+	// Tamper with the module content after the hash was recorded.
+	if err := afero.WriteFile(fs, dir+"/file.txt", []byte("tampered content"), 0o644); err != nil {
+		t.Fatalf("rewrite module file: %v", err)
+	}
+
+	hash2, err := hashModuleDir(fs, dir)
+	if err != nil {
+		t.Fatalf("hashModuleDir: %v", err)
+	}
+	if hash1 == hash2 {
+		t.Fatalf("expected hashModuleDir to detect the content change, got the same hash %q", hash1)
+	}
+}
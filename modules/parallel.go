@@ -0,0 +1,168 @@
+// Copyright 2019 The Hugo Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package modules
+
+import (
+	"context"
+	"runtime"
+	"sync"
+)
+
+// workerCount is how many resolution tasks addAndRecurse runs concurrently.
+// It defaults to GOMAXPROCS, but can be set via c.workers (e.g. for tests
+// that want a deterministic, single-worker walk).
+func (c *collector) workerCount() int {
+	if c.workers > 0 {
+		return c.workers
+	}
+	if n := runtime.GOMAXPROCS(0); n > 0 {
+		return n
+	}
+	return 1
+}
+
+// importNode is one node of the import graph discovered while resolving
+// modules in parallel. children is pre-sized to the owning module's import
+// list so worker goroutines can write results back by index regardless of
+// completion order; walking it in that index order afterwards reproduces
+// the module order the old, strictly serial depth-first walk produced.
+//
+// path is this node's position in the import tree, e.g. []int{1, 0} for
+// "the project's 2nd import's 1st import" -- it never changes once set, so
+// it can be used (see collector.claim) to arbitrate precedence between two
+// subtrees that import the same module, independent of goroutine timing.
+type importNode struct {
+	mod      *moduleAdapter
+	path     []int
+	children []*importNode
+}
+
+// prune drops node (and transitively, everything under it) from the
+// result: appendResolved skips any child whose mod is nil, without
+// descending into its children.
+func (n *importNode) prune() {
+	n.mod = nil
+}
+
+// appendResolved walks node's children in their original import order,
+// appending each resolved module immediately followed by its own children -
+// the same pre-order a serial depth-first walk would have produced.
+func appendResolved(modules *Modules, node *importNode) {
+	for _, child := range node.children {
+		if child == nil || child.mod == nil {
+			continue
+		}
+		*modules = append(*modules, child.mod)
+		appendResolved(modules, child)
+	}
+}
+
+// resolveGroup runs resolution tasks against a bounded worker pool. The
+// first error returned by any task cancels the shared context so the rest
+// of the in-flight (and not yet started) tasks can stop early.
+type resolveGroup struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	sem chan struct{}
+	wg  sync.WaitGroup
+
+	mu  sync.Mutex
+	err error
+}
+
+func newResolveGroup(workers int) *resolveGroup {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &resolveGroup{
+		ctx:    ctx,
+		cancel: cancel,
+		sem:    make(chan struct{}, workers),
+	}
+}
+
+// run submits fn to the pool. It may itself call run again (e.g. to recurse
+// into a just-resolved module's own imports) before returning.
+func (g *resolveGroup) run(fn func(ctx context.Context) error) {
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+
+		select {
+		case g.sem <- struct{}{}:
+		case <-g.ctx.Done():
+			return
+		}
+		defer func() { <-g.sem }()
+
+		if g.ctx.Err() != nil {
+			return
+		}
+
+		if err := fn(g.ctx); err != nil {
+			g.mu.Lock()
+			if g.err == nil {
+				g.err = err
+				g.cancel()
+			}
+			g.mu.Unlock()
+		}
+	}()
+}
+
+// wait blocks until every submitted (and any task-scheduled) task has
+// returned, then returns the first error encountered, if any.
+func (g *resolveGroup) wait() error {
+	g.wg.Wait()
+	g.cancel()
+	return g.err
+}
+
+// singleflightKeys coalesces concurrent callers for the same key so that,
+// for example, "go get" is never shelled out for the same module path twice
+// at once; the first caller does the work and the rest share its result.
+type singleflightKeys struct {
+	mu    sync.Mutex
+	calls map[string]*sfCall
+}
+
+type sfCall struct {
+	wg  sync.WaitGroup
+	err error
+}
+
+func (s *singleflightKeys) do(key string, fn func() error) error {
+	s.mu.Lock()
+	if s.calls == nil {
+		s.calls = make(map[string]*sfCall)
+	}
+	if call, found := s.calls[key]; found {
+		s.mu.Unlock()
+		call.wg.Wait()
+		return call.err
+	}
+
+	call := &sfCall{}
+	call.wg.Add(1)
+	s.calls[key] = call
+	s.mu.Unlock()
+
+	call.err = fn()
+	call.wg.Done()
+
+	s.mu.Lock()
+	delete(s.calls, key)
+	s.mu.Unlock()
+
+	return call.err
+}
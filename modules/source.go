@@ -0,0 +1,273 @@
+// Copyright 2019 The Hugo Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package modules
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+)
+
+// ModuleSource resolves and fetches the content of a module, given its
+// import path and version. Hugo ships with sources for Go modules and
+// plain directories below /themes, but an Import may select any registered
+// source by name (e.g. source = "git").
+type ModuleSource interface {
+	// Name is the value used in the `source` config key of an Import to
+	// select this backend.
+	Name() string
+
+	// Resolve resolves moduleImport into a local directory holding the
+	// module's content. It returns the resolved directory and the concrete
+	// version that was resolved, which may differ from the requested
+	// version (e.g. a branch name resolves to a commit SHA).
+	Resolve(ctx context.Context, c *collector, moduleImport Import) (dir string, resolvedVersion string, err error)
+
+	// Fetch prepares the source for use, e.g. downloading an index or
+	// priming a cache. It is a no-op for sources with nothing to do ahead
+	// of Resolve.
+	Fetch(ctx context.Context) error
+}
+
+// defaultModuleSources returns the sources tried, in order, for an Import
+// that does not set a `source`.
+func defaultModuleSources(c *collector) []ModuleSource {
+	return []ModuleSource{
+		&goModuleSource{},
+		&themeDirModuleSource{},
+	}
+}
+
+// moduleSourceByName returns the registered source for name, or an error if
+// name does not match any of them. name is matched as given in the Import's
+// `source` config key, e.g. "git" or "oci".
+func moduleSourceByName(name string) (ModuleSource, error) {
+	switch name {
+	case "", "gomod":
+		return &goModuleSource{}, nil
+	case "theme":
+		return &themeDirModuleSource{}, nil
+	case "git":
+		return &gitModuleSource{}, nil
+	case "oci":
+		return &ociModuleSource{}, nil
+	default:
+		return nil, errors.Errorf("unknown module source %q", name)
+	}
+}
+
+// goModuleSource resolves modules from the project's go.mod, falling back
+// to "go get" when a module isn't already in the local module cache.
+type goModuleSource struct{}
+
+func (s *goModuleSource) Name() string { return "gomod" }
+
+func (s *goModuleSource) Fetch(ctx context.Context) error { return nil }
+
+func (s *goModuleSource) Resolve(ctx context.Context, c *collector, moduleImport Import) (string, string, error) {
+	mod, err := c.resolveGoModule(moduleImport.Path)
+	if err != nil {
+		return "", "", err
+	}
+	if mod == nil {
+		return "", "", ErrNotExist
+	}
+
+	return mod.Dir, mod.Version, nil
+}
+
+// themeDirModuleSource resolves modules stored as a plain directory below
+// the project's themesDir, Hugo's original (pre-Modules) theme mechanism.
+type themeDirModuleSource struct{}
+
+func (s *themeDirModuleSource) Name() string { return "theme" }
+
+func (s *themeDirModuleSource) Fetch(ctx context.Context) error { return nil }
+
+func (s *themeDirModuleSource) Resolve(ctx context.Context, c *collector, moduleImport Import) (string, string, error) {
+	dir := filepath.Join(c.themesDir, moduleImport.Path)
+	if found, _ := afero.Exists(c.fs, dir); !found {
+		return "", "", ErrNotExist
+	}
+	return dir, "", nil
+}
+
+// gitModuleSource resolves modules by cloning (or updating) a plain git
+// repository and checking out the requested ref, entirely independent of
+// the Go toolchain. The URL to clone is read from Import.URL, and the ref
+// to check out (tag, branch or commit) from Import.Ref/version.
+type gitModuleSource struct{}
+
+func (s *gitModuleSource) Name() string { return "git" }
+
+func (s *gitModuleSource) Fetch(ctx context.Context) error { return nil }
+
+func (s *gitModuleSource) Resolve(ctx context.Context, c *collector, moduleImport Import) (string, string, error) {
+	path, url, ref := moduleImport.Path, moduleImport.URL, moduleImport.Ref
+	if url == "" || ref == "" {
+		return "", "", errors.Errorf("git module source: %q needs both url and ref set", path)
+	}
+
+	cacheDir, err := sourceCacheDir(c, "git", path)
+	if err != nil {
+		return "", "", err
+	}
+
+	if found, _ := afero.Exists(c.fs, filepath.Join(cacheDir, ".git")); !found {
+		if err := os.MkdirAll(filepath.Dir(cacheDir), 0o755); err != nil {
+			return "", "", err
+		}
+		if err := runModuleSourceCmd(ctx, "", "git", "clone", "--quiet", url, cacheDir); err != nil {
+			return "", "", errors.Wrapf(err, "failed to clone %q", url)
+		}
+	}
+
+	if err := runModuleSourceCmd(ctx, cacheDir, "git", "fetch", "--quiet", "--tags"); err != nil {
+		return "", "", errors.Wrapf(err, "failed to fetch %q", url)
+	}
+
+	if err := runModuleSourceCmd(ctx, cacheDir, "git", "checkout", "--quiet", ref); err != nil {
+		return "", "", errors.Wrapf(err, "failed to checkout %q at %q", url, ref)
+	}
+
+	return cacheDir, ref, nil
+}
+
+func runModuleSourceCmd(ctx context.Context, dir string, name string, args ...string) error {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return errors.Wrap(err, string(out))
+	}
+	return nil
+}
+
+// ociModuleSource resolves modules distributed as a plain tarball over
+// HTTPS (an OCI registry blob URL or any other signed download URL declared
+// in Import.URL), downloading and extracting it into a cache dir.
+type ociModuleSource struct{}
+
+func (s *ociModuleSource) Name() string { return "oci" }
+
+func (s *ociModuleSource) Fetch(ctx context.Context) error { return nil }
+
+func (s *ociModuleSource) Resolve(ctx context.Context, c *collector, moduleImport Import) (string, string, error) {
+	path, url, ref := moduleImport.Path, moduleImport.URL, moduleImport.Ref
+	if url == "" {
+		return "", "", errors.Errorf("oci module source: %q needs a url", path)
+	}
+
+	cacheDir, err := sourceCacheDir(c, "oci", path+"@"+ref)
+	if err != nil {
+		return "", "", err
+	}
+
+	if found, _ := afero.Exists(c.fs, cacheDir); found {
+		return cacheDir, ref, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", "", err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", "", errors.Wrapf(err, "failed to download %q", url)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", "", errors.Errorf("failed to download %q: got HTTP status %d", url, resp.StatusCode)
+	}
+
+	if err := extractTarGz(resp.Body, cacheDir); err != nil {
+		return "", "", errors.Wrapf(err, "failed to extract %q", url)
+	}
+
+	return cacheDir, ref, nil
+}
+
+func extractTarGz(r io.Reader, dir string) error {
+	gzr, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer gzr.Close()
+
+	tr := tar.NewReader(gzr)
+
+	dir = filepath.Clean(dir)
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if filepath.IsAbs(hdr.Name) {
+			return errors.Errorf("refusing to extract %q: absolute path in archive", hdr.Name)
+		}
+
+		target := filepath.Join(dir, hdr.Name)
+
+		if target != dir && !strings.HasPrefix(target, dir+string(filepath.Separator)) {
+			return errors.Errorf("refusing to extract %q: escapes destination %q", hdr.Name, dir)
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0o755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.FileMode(hdr.Mode))
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(f, tr); err != nil {
+				f.Close()
+				return err
+			}
+			f.Close()
+		}
+	}
+}
+
+// sourceCacheDir returns a stable on-disk location for a resolved module,
+// namespaced by source and import path so two backends never collide.
+func sourceCacheDir(c *collector, source, path string) (string, error) {
+	base := filepath.Join(c.workingDir, ".hugo_cache", "modules", source)
+	return filepath.Join(base, filepath.FromSlash(path)), nil
+}
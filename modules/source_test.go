@@ -0,0 +1,105 @@
+// Copyright 2019 The Hugo Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package modules
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func buildTarGz(t *testing.T, entries map[string]string) *bytes.Buffer {
+	t.Helper()
+
+	var buf bytes.Buffer
+	gzw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gzw)
+
+	for name, content := range entries {
+		hdr := &tar.Header{
+			Name: name,
+			Mode: 0o644,
+			Size: int64(len(content)),
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf("write header: %v", err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatalf("write content: %v", err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		t.Fatalf("close tar writer: %v", err)
+	}
+	if err := gzw.Close(); err != nil {
+		t.Fatalf("close gzip writer: %v", err)
+	}
+
+	return &buf
+}
+
+func TestExtractTarGzRejectsPathTraversal(t *testing.T) {
+	cases := []struct {
+		name  string
+		entry string
+	}{
+		{"dot-dot segment", "../escaped.txt"},
+		{"nested dot-dot segment", "a/../../escaped.txt"},
+		{"absolute path", "/etc/escaped.txt"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			target := filepath.Join(dir, "cache")
+
+			archive := buildTarGz(t, map[string]string{tc.entry: "pwned"})
+
+			err := extractTarGz(archive, target)
+			if err == nil {
+				t.Fatalf("expected extractTarGz to reject entry %q, got no error", tc.entry)
+			}
+
+			if _, statErr := os.Stat(filepath.Join(filepath.Dir(target), "escaped.txt")); !os.IsNotExist(statErr) {
+				t.Fatalf("expected no file to be written outside %q for entry %q", target, tc.entry)
+			}
+		})
+	}
+}
+
+func TestExtractTarGzAllowsWellFormedArchive(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "cache")
+
+	archive := buildTarGz(t, map[string]string{
+		"README.md":        "hello",
+		"sub/dir/file.txt": "world",
+	})
+
+	if err := extractTarGz(archive, target); err != nil {
+		t.Fatalf("extractTarGz: %v", err)
+	}
+
+	got, err := os.ReadFile(filepath.Join(target, "sub", "dir", "file.txt"))
+	if err != nil {
+		t.Fatalf("read extracted file: %v", err)
+	}
+	if string(got) != "world" {
+		t.Fatalf("got %q, want %q", got, "world")
+	}
+}